@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	adminPort     = ":8080"
+	adminTokenEnv = "WHITERABBIT_ADMIN_TOKEN"
+)
+
+// recordJSON is the wire representation of a single DNS record used by the
+// admin API.
+type recordJSON struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+	TTL  uint32 `json:"ttl"`
+}
+
+// startAdminServer starts the embedded HTTP admin API in the background. If
+// the WHITERABBIT_ADMIN_TOKEN environment variable is set, every request
+// must carry a matching "Authorization: Bearer <token>" header.
+func startAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/records", withAuth(handleRecords))
+	mux.HandleFunc("/records/", withAuth(handleRecordByName))
+	mux.HandleFunc("/lists/allow", withAuth(handleListAllow))
+	mux.HandleFunc("/lists/deny", withAuth(handleListDeny))
+	mux.HandleFunc("/cache", withAuth(handleCacheInspect))
+	mux.HandleFunc("/cache/flush", withAuth(handleCacheFlush))
+	mux.HandleFunc("/denied", withAuth(handleDeniedLog))
+
+	go func() {
+		log.Printf("Admin API listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Admin API stopped: %v", err)
+		}
+	}()
+}
+
+// withAuth wraps next with a bearer-token check when adminTokenEnv is set.
+func withAuth(next http.HandlerFunc) http.HandlerFunc {
+	token := os.Getenv(adminTokenEnv)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleRecords(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rrs := records.all()
+		out := make([]recordJSON, 0, len(rrs))
+		for _, rr := range rrs {
+			out = append(out, toRecordJSON(rr))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var rec recordJSON
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(rec.Name), rec.TTL, strings.ToUpper(rec.Type), rec.Data))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid record: %v", err), http.StatusBadRequest)
+			return
+		}
+		records.add(rr)
+		if err := records.save(recordsFile); err != nil {
+			http.Error(w, fmt.Sprintf("failed to persist record: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, rec)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRecordByName implements DELETE /records/{name}/{type}.
+func handleRecordByName(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/records/"), "/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "expected /records/{name}/{type}", http.StatusBadRequest)
+		return
+	}
+	name, typeName := parts[0], strings.ToUpper(parts[1])
+
+	qtype, ok := dns.StringToType[typeName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown record type: %s", typeName), http.StatusBadRequest)
+		return
+	}
+
+	records.remove(name, qtype)
+	if err := records.save(recordsFile); err != nil {
+		http.Error(w, fmt.Sprintf("failed to persist record removal: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listRequest struct {
+	Domain string `json:"domain"`
+}
+
+func handleListAllow(w http.ResponseWriter, r *http.Request) {
+	handleListAdd(w, r, matcher.addAllow, matcher.allowLines, allowlistFile)
+}
+
+func handleListDeny(w http.ResponseWriter, r *http.Request) {
+	handleListAdd(w, r, matcher.addDeny, matcher.denyLines, blocklistFile)
+}
+
+func handleListAdd(w http.ResponseWriter, r *http.Request, add func(string), lines func() []string, filename string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req listRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Domain == "" {
+		http.Error(w, `expected {"domain": "..."}`, http.StatusBadRequest)
+		return
+	}
+
+	add(req.Domain)
+	if err := atomicWriteLines(filename, lines()); err != nil {
+		http.Error(w, fmt.Sprintf("failed to persist list: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleCacheInspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, cache.snapshot())
+}
+
+func handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cache.flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeniedLog tails the structured query log, returning just the
+// entries decided "deny", newest last.
+func handleDeniedLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := os.ReadFile(queryLogFile)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("failed to read %s: %v", queryLogFile, err), http.StatusInternalServerError)
+		return
+	}
+
+	var denied []queryLogEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var entry queryLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if entry.Decision == decisionDeny {
+			denied = append(denied, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, denied)
+}
+
+func toRecordJSON(rr dns.RR) recordJSON {
+	h := rr.Header()
+	return recordJSON{
+		Name: h.Name,
+		Type: dns.TypeToString[h.Rrtype],
+		TTL:  h.Ttl,
+		Data: strings.TrimPrefix(rr.String(), h.String()),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultNegativeTTL is how long NXDOMAIN/NODATA results are cached. Every
+// negative result is kept for this full duration; the SOA TTL/MINIMUM of the
+// upstream's negative answer is not consulted.
+const defaultNegativeTTL = 5 * time.Minute
+
+// cacheKey identifies a cached answer by owner name and query type.
+type cacheKey struct {
+	name  string
+	qtype uint16
+}
+
+// cacheEntry holds resolved answers (or a negative result) together with the
+// absolute time it expires at. nxdomain only applies when negative is true,
+// distinguishing a cached NXDOMAIN from a cached NODATA (the name exists but
+// has no records of the cached type).
+type cacheEntry struct {
+	rrs      []dns.RR
+	negative bool
+	nxdomain bool
+	expires  time.Time
+}
+
+// cacheMetrics counts cache effectiveness for observability.
+type cacheMetrics struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// resolverCache is a TTL-aware cache for upstream answers, including
+// negative (NXDOMAIN/NODATA) results. Entries are evicted lazily on lookup
+// rather than by a background sweep.
+type resolverCache struct {
+	mu          sync.RWMutex
+	entries     map[cacheKey]cacheEntry
+	negativeTTL time.Duration
+	metrics     cacheMetrics
+}
+
+func newResolverCache() *resolverCache {
+	return &resolverCache{
+		entries:     make(map[cacheKey]cacheEntry),
+		negativeTTL: defaultNegativeTTL,
+	}
+}
+
+// get returns the cached answers for (name, qtype). found reports whether a
+// live entry existed; negative reports whether it recorded an NXDOMAIN/NODATA
+// result rather than real answers, and nxdomain (only meaningful when
+// negative is true) distinguishes the two.
+func (c *resolverCache) get(name string, qtype uint16) (rrs []dns.RR, negative, nxdomain bool, found bool) {
+	key := cacheKey{name: strings.ToLower(name), qtype: qtype}
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		c.bump(&c.metrics.Misses)
+		return nil, false, false, false
+	}
+	if time.Now().After(entry.expires) {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.metrics.Evictions++
+		c.mu.Unlock()
+		c.bump(&c.metrics.Misses)
+		return nil, false, false, false
+	}
+
+	c.bump(&c.metrics.Hits)
+	return entry.rrs, entry.negative, entry.nxdomain, true
+}
+
+// set stores a positive answer set, expiring after the lowest TTL among rrs.
+func (c *resolverCache) set(name string, qtype uint16, rrs []dns.RR) {
+	if len(rrs) == 0 {
+		return
+	}
+	ttl := rrs[0].Header().Ttl
+	for _, rr := range rrs[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	key := cacheKey{name: strings.ToLower(name), qtype: qtype}
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{rrs: rrs, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+	c.mu.Unlock()
+}
+
+// setNegative records an NXDOMAIN/NODATA result for (name, qtype), expiring
+// after the cache's configured negative TTL. nxdomain distinguishes a cached
+// NXDOMAIN from a cached NODATA so get can replay the correct rcode later.
+func (c *resolverCache) setNegative(name string, qtype uint16, nxdomain bool) {
+	key := cacheKey{name: strings.ToLower(name), qtype: qtype}
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{negative: true, nxdomain: nxdomain, expires: time.Now().Add(c.negativeTTL)}
+	c.mu.Unlock()
+}
+
+// snapshot returns a copy of the current metrics counters.
+func (c *resolverCache) snapshot() cacheMetrics {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.metrics
+}
+
+// flush discards every cached entry without touching the hit/miss/eviction
+// counters.
+func (c *resolverCache) flush() {
+	c.mu.Lock()
+	c.entries = make(map[cacheKey]cacheEntry)
+	c.mu.Unlock()
+}
+
+func (c *resolverCache) bump(counter *uint64) {
+	c.mu.Lock()
+	*counter++
+	c.mu.Unlock()
+}
@@ -1,184 +1,225 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
-	"net/url"
-	"os"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
 const (
-	port = ":5353"
+	port          = ":5353"
+	upstreamsFile = "upstreams.yaml"
+	allowlistFile = "whitelist.txt"
+	blocklistFile = "blocklist.txt"
+	recordsFile   = "dns_records.txt"
+	queryLogFile  = "query.log"
+
+	// defaultRateLimit and defaultRateBurst bound how many queries per
+	// second a single client IP may make before getting REFUSED, to
+	// mitigate the resolver being used for amplification abuse.
+	defaultRateLimit = 20
+	defaultRateBurst = 40
 )
 
-var dnsRecords = make(map[string]string)
+var records *zone
 
-type DNSResponse struct {
-	Status int         `json:"Status"`
-	Answer []DNSAnswer `json:"Answer"`
-}
+var upstreams *upstreamRouter
 
-type DNSAnswer struct {
-	Name string `json:"name"`
-	Type int    `json:"type"`
-	TTL  int    `json:"TTL"`
-	Data string `json:"data"`
-}
+var cache *resolverCache
 
-func loadRecords(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+var matcher *DomainMatcher
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) != 2 {
-			return fmt.Errorf("invalid record: %s", line)
-		}
-		dnsRecords[fields[0]] = fields[1]
-	}
+var queryLog *queryLogger
 
-	return scanner.Err()
-}
+var rateLimiter *clientRateLimiter
 
-func checkWhitelist(domain string) bool {
-	file, err := os.Open("whitelist.txt")
-	if err != nil {
-		log.Printf("Failed to open whitelist file: %v", err)
-		return false
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		if line == domain {
-			return true
-		}
-		//check if domain is a subdomain of a whitelisted domain
-		if strings.HasSuffix(domain, line) {
-			return true
-		}
-	}
-	return false
-}
-
-func askExternalDNS(domain string) (string, error) {
-	baseURL := "https://dns.google.com/resolve"
-	query := url.Values{}
-	query.Set("name", domain)
-
-	fullURL := fmt.Sprintf("%s?%s", baseURL, query.Encode())
-	resp, err := http.Get(fullURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to query DNS: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected HTTP status: %s", resp.Status)
-	}
+func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
+	msg := dns.Msg{}
+	msg.SetReply(r)
+	msg.Authoritative = true
 
-	var dnsResp DNSResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dnsResp); err != nil {
-		return "", fmt.Errorf("failed to parse DNS response: %v", err)
+	clientIP := clientIPFromAddr(w.RemoteAddr())
+
+	// msg.Rcode is one field shared by the whole reply, but each loop
+	// iteration below sets it from that question's own outcome; with more
+	// than one question, a later (or earlier) question's rcode would
+	// silently clobber another's. Rather than mix per-question rcodes into
+	// one response, refuse to answer anything but the single-question case
+	// virtually every real client sends.
+	if len(r.Question) != 1 {
+		msg.Rcode = dns.RcodeFormatError
+		w.WriteMsg(&msg)
+		return
 	}
 
-	if dnsResp.Status != 0 {
-		return "", fmt.Errorf("DNS query failed with status: %d", dnsResp.Status)
+	var edns *ednsOptions
+	bufSize := uint16(dns.MinMsgSize)
+	do := false
+	if opt := r.IsEdns0(); opt != nil {
+		bufSize = opt.UDPSize()
+		do = opt.Do()
+		edns = &ednsOptions{bufSize: bufSize, do: do}
 	}
 
-	if len(dnsResp.Answer) == 0 {
-		return "", fmt.Errorf("no DNS answer found")
+	if !rateLimiter.allow(clientIP) {
+		msg.Rcode = dns.RcodeRefused
+		for _, question := range r.Question {
+			logQuery(clientIP, question, decisionRefused, "", 0, msg.Rcode)
+		}
+		w.WriteMsg(&msg)
+		return
 	}
 
-	return dnsResp.Answer[0].Data, nil
-}
-
-func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
-	msg := dns.Msg{}
-	msg.SetReply(r)
-	msg.Authoritative = true
-
 	for _, question := range r.Question {
+		start := time.Now()
 		domain := strings.ToLower(question.Name)
+		qtype := question.Qtype
 
-		ip, found := dnsRecords[domain]
-		if !found {
-			if checkWhitelist(domain) {
-				var err error
-				ip, err = askExternalDNS(domain)
-				if err != nil {
-					log.Printf("Failed to query external DNS: %v", err)
-					continue
+		rrs, nameExists := records.lookup(domain, qtype)
+		if len(rrs) > 0 {
+			msg.Answer = append(msg.Answer, rrs...)
+			logQuery(clientIP, question, decisionLocal, "", time.Since(start), dns.RcodeSuccess)
+			continue
+		}
+		if nameExists {
+			// NODATA: the name is ours, it just has no record of this type.
+			if records.soa != nil {
+				msg.Ns = append(msg.Ns, records.soa)
+			}
+			logQuery(clientIP, question, decisionNoData, "", time.Since(start), dns.RcodeSuccess)
+			continue
+		}
+
+		if cached, negative, nxdomain, found := cache.get(domain, qtype); found {
+			if negative {
+				if nxdomain {
+					msg.Rcode = dns.RcodeNameError
 				}
-				dnsRecords[domain] = ip
 			} else {
-				writeDeniedLog(domain)
-				continue
+				msg.Answer = append(msg.Answer, cached...)
+			}
+			logQuery(clientIP, question, decisionCached, "", time.Since(start), msg.Rcode)
+			continue
+		}
+
+		allowed, blocked := matcher.classify(domain)
+		if blocked {
+			if sinkholeIP := matcher.sinkhole(); sinkholeIP != "" && qtype == dns.TypeA {
+				if rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN A %s", domain, sinkholeIP)); err == nil {
+					msg.Answer = append(msg.Answer, rr)
+					logQuery(clientIP, question, decisionDeny, "", time.Since(start), dns.RcodeSuccess)
+					continue
+				}
 			}
+			msg.Rcode = dns.RcodeNameError
+			logQuery(clientIP, question, decisionDeny, "", time.Since(start), msg.Rcode)
+			continue
+		}
+		if !allowed {
+			msg.Rcode = dns.RcodeNameError
+			logQuery(clientIP, question, decisionDeny, "", time.Since(start), msg.Rcode)
+			continue
 		}
 
-		rr, err := dns.NewRR(fmt.Sprintf("%s A %s", domain, ip))
+		upstream, upstreamName := upstreams.resolveRoute(domain)
+		if upstream == nil {
+			log.Printf("No upstream configured for %s", domain)
+			continue
+		}
+		answers, rcode, err := upstream.Resolve(domain, qtype, edns)
 		if err != nil {
-			log.Printf("Failed to create DNS record: %v", err)
+			log.Printf("Failed to query upstream for %s: %v", domain, err)
 			continue
 		}
-		msg.Answer = append(msg.Answer, rr)
+		if rcode == dns.RcodeNameError {
+			cache.setNegative(domain, qtype, true)
+			msg.Rcode = dns.RcodeNameError
+			logQuery(clientIP, question, decisionForwarded, upstreamName, time.Since(start), msg.Rcode)
+			continue
+		}
+		if len(answers) == 0 {
+			// NODATA: the upstream has the name, just no records of this type.
+			cache.setNegative(domain, qtype, false)
+			logQuery(clientIP, question, decisionForwarded, upstreamName, time.Since(start), dns.RcodeSuccess)
+			continue
+		}
+		cache.set(domain, qtype, answers)
+		msg.Answer = append(msg.Answer, answers...)
+		logQuery(clientIP, question, decisionForwarded, upstreamName, time.Since(start), dns.RcodeSuccess)
+	}
+
+	if edns != nil {
+		msg.SetEdns0(bufSize, do)
 	}
+	truncateForUDP(w, &msg, bufSize)
 
 	w.WriteMsg(&msg)
 }
 
-func writeDeniedLog(domain string) {
-	file, err := os.OpenFile("denied.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Printf("Failed to open denied.log: %v", err)
+// truncateForUDP sets the TC bit and drops the answer/authority sections
+// when msg, sent over UDP, would exceed the client's negotiated EDNS0
+// buffer size (or the default 512 bytes without EDNS0); the client is then
+// expected to retry the query over TCP.
+func truncateForUDP(w dns.ResponseWriter, msg *dns.Msg, bufSize uint16) {
+	if _, isUDP := w.RemoteAddr().(*net.UDPAddr); !isUDP {
 		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == domain {
-			return
-		}
+	packed, err := msg.Pack()
+	if err != nil || len(packed) <= int(bufSize) {
+		return
 	}
 
-	if _, err := file.WriteString(domain + "\n"); err != nil {
-		log.Printf("Failed to write to denied.log: %v", err)
-	}
+	msg.Truncated = true
+	msg.Answer = nil
+	msg.Ns = nil
 }
 
 func main() {
-	err := loadRecords("dns_records.txt")
+	var err error
+	records, err = loadRecords(recordsFile)
 	if err != nil {
 		log.Fatalf("Failed to load DNS records: %v", err)
 	}
 
-	dns.HandleFunc(".", handleRequest)
+	upstreams, err = loadUpstreamsOrDefault(upstreamsFile)
+	if err != nil {
+		log.Fatalf("Failed to load upstream routing table: %v", err)
+	}
+
+	cache = newResolverCache()
+
+	matcher, err = newDomainMatcher(allowlistFile, blocklistFile)
+	if err != nil {
+		log.Fatalf("Failed to load allow/deny lists: %v", err)
+	}
 
-	server := &dns.Server{Addr: port, Net: "udp"}
-	log.Printf("DNS resolver server listening on %s", port)
-	err = server.ListenAndServe()
+	queryLog, err = newQueryLogger(queryLogFile)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		log.Fatalf("Failed to open query log: %v", err)
+	}
+
+	rateLimiter = newClientRateLimiter(defaultRateLimit, defaultRateBurst)
+
+	startAdminServer(adminPort)
+
+	dns.HandleFunc(".", handleRequest)
+
+	tcpServer := &dns.Server{Addr: port, Net: "tcp"}
+	go func() {
+		log.Printf("DNS resolver server listening on %s (tcp)", port)
+		if err := tcpServer.ListenAndServe(); err != nil {
+			log.Fatalf("Failed to start TCP server: %v", err)
+		}
+	}()
+
+	udpServer := &dns.Server{Addr: port, Net: "udp"}
+	log.Printf("DNS resolver server listening on %s (udp)", port)
+	if err := udpServer.ListenAndServe(); err != nil {
+		log.Fatalf("Failed to start UDP server: %v", err)
 	}
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DomainMatcher loads allow and deny lists once from disk and hot-reloads
+// them on change, matching queries on label boundaries so a rule for
+// "example.com" matches "www.example.com" but not "evil-example.com".
+type DomainMatcher struct {
+	mu         sync.RWMutex
+	allow      map[string]bool
+	deny       map[string]bool
+	sinkholeIP string
+
+	allowFile string
+	denyFile  string
+}
+
+// newDomainMatcher loads allowFile and denyFile and starts watching both for
+// changes. denyFile may contain a "sinkhole: <ip>" directive line to answer
+// blocked A queries with a fixed address instead of NXDOMAIN; blocked
+// queries of any other type still get NXDOMAIN.
+func newDomainMatcher(allowFile, denyFile string) (*DomainMatcher, error) {
+	m := &DomainMatcher{allowFile: allowFile, denyFile: denyFile}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	if err := m.watch(); err != nil {
+		log.Printf("Not watching allow/deny lists for changes: %v", err)
+	}
+	return m, nil
+}
+
+func (m *DomainMatcher) reload() error {
+	allow, err := loadDomainSet(m.allowFile)
+	if err != nil {
+		return err
+	}
+	deny, sinkholeIP, err := loadDenySet(m.denyFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.allow = allow
+	m.deny = deny
+	m.sinkholeIP = sinkholeIP
+	m.mu.Unlock()
+	return nil
+}
+
+// watch hot-reloads the allow/deny lists on change. It watches the
+// containing directories rather than the files themselves: admin.go's list
+// endpoints write via atomicWriteLines (and many editors save the same way),
+// which replaces a file by renaming a temp file over it, and on Linux that moves the inotify
+// watch to the old, now-unlinked inode rather than following the name. A
+// directory watch survives the rename, so events are filtered down to the
+// basenames we actually care about.
+func (m *DomainMatcher) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	basenames := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, f := range []string{m.allowFile, m.denyFile} {
+		if f == "" {
+			continue
+		}
+		dirs[filepath.Dir(f)] = true
+		basenames[filepath.Base(f)] = true
+	}
+	if len(dirs) == 0 {
+		watcher.Close()
+		return nil
+	}
+
+	watched := 0
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Not watching %s for changes: %v", dir, err)
+			continue
+		}
+		watched++
+	}
+	if watched == 0 {
+		watcher.Close()
+		return nil
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !basenames[filepath.Base(event.Name)] {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+					if err := m.reload(); err != nil {
+						log.Printf("Failed to reload allow/deny lists: %v", err)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Allow/deny list watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// classify reports whether domain is allowed to be resolved and, separately,
+// whether it was explicitly blocked. An explicit allow entry always wins
+// over a deny entry, mirroring common blocklist tools (Pi-hole style); with
+// neither list matching, the domain is treated as not allowed, preserving
+// this resolver's original default-deny behavior.
+func (m *DomainMatcher) classify(domain string) (allowed, blocked bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if domainOrParentIn(m.allow, domain) {
+		return true, false
+	}
+	if domainOrParentIn(m.deny, domain) {
+		return false, true
+	}
+	return false, false
+}
+
+// sinkhole returns the configured sinkhole address for blocked queries, or
+// "" if blocked queries should get NXDOMAIN instead.
+func (m *DomainMatcher) sinkhole() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sinkholeIP
+}
+
+// addAllow adds domain to the in-memory allow list. Callers that want the
+// change to survive a restart must also persist it via allowLines and
+// atomicWriteLines (see admin.go).
+func (m *DomainMatcher) addAllow(domain string) {
+	m.mu.Lock()
+	m.allow[dns.Fqdn(strings.ToLower(domain))] = true
+	m.mu.Unlock()
+}
+
+// addDeny adds domain to the in-memory deny list; see addAllow.
+func (m *DomainMatcher) addDeny(domain string) {
+	m.mu.Lock()
+	m.deny[dns.Fqdn(strings.ToLower(domain))] = true
+	m.mu.Unlock()
+}
+
+// allowLines renders the current allow list back into loadDomainSet's file
+// format, sorted for a stable diff.
+func (m *DomainMatcher) allowLines() []string {
+	m.mu.RLock()
+	domains := make([]string, 0, len(m.allow))
+	for d := range m.allow {
+		domains = append(domains, d)
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(domains)
+	return domains
+}
+
+// denyLines renders the current deny list back into loadDenySet's file
+// format, re-emitting the sinkhole directive first when one is configured.
+func (m *DomainMatcher) denyLines() []string {
+	m.mu.RLock()
+	domains := make([]string, 0, len(m.deny))
+	for d := range m.deny {
+		domains = append(domains, d)
+	}
+	sinkholeIP := m.sinkholeIP
+	m.mu.RUnlock()
+
+	sort.Strings(domains)
+	if sinkholeIP == "" {
+		return domains
+	}
+	return append([]string{"sinkhole: " + sinkholeIP}, domains...)
+}
+
+// domainOrParentIn reports whether set contains domain or one of its parent
+// domains, stopping at domain's public suffix + 1 so a rule never matches
+// purely on a shared bare TLD/eTLD.
+func domainOrParentIn(set map[string]bool, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	etld1, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return set[dns.Fqdn(domain)]
+	}
+
+	for {
+		if set[dns.Fqdn(domain)] {
+			return true
+		}
+		if domain == etld1 {
+			return false
+		}
+		idx := strings.Index(domain, ".")
+		if idx < 0 {
+			return false
+		}
+		domain = domain[idx+1:]
+	}
+}
+
+// loadDomainSet reads one FQDN per line, normalized to lowercase. A missing
+// file is treated as an empty set, since both lists are optional.
+func loadDomainSet(filename string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	if filename == "" {
+		return set, nil
+	}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[dns.Fqdn(strings.ToLower(line))] = true
+	}
+	return set, scanner.Err()
+}
+
+// loadDenySet behaves like loadDomainSet but also recognizes a leading
+// "sinkhole: <ip>" directive line, e.g.:
+//
+//	sinkhole: 0.0.0.0
+//	ads.example.com
+//	tracker.example.net
+func loadDenySet(filename string) (set map[string]bool, sinkholeIP string, err error) {
+	set = make(map[string]bool)
+	if filename == "" {
+		return set, "", nil
+	}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return set, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "sinkhole:"); ok {
+			sinkholeIP = strings.TrimSpace(rest)
+			continue
+		}
+		set[dns.Fqdn(strings.ToLower(line))] = true
+	}
+	return set, sinkholeIP, scanner.Err()
+}
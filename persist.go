@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// atomicWriteLines writes lines to filename, one per line, via a temp file
+// plus rename so a concurrent reader never observes a partially written
+// file (used by the admin API to persist live record/list edits).
+func atomicWriteLines(filename string, lines []string) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tmp, line); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultMaxQueryLogBytes bounds query.log's size before it's rotated aside.
+const defaultMaxQueryLogBytes = 10 * 1024 * 1024 // 10 MiB
+
+// Query decisions recorded in the structured query log.
+const (
+	decisionLocal     = "local"
+	decisionNoData    = "nodata"
+	decisionCached    = "cached"
+	decisionDeny      = "deny"
+	decisionForwarded = "forwarded"
+	decisionRefused   = "refused"
+)
+
+// queryLogEntry is one structured log line emitted per processed question,
+// replacing the old denied-only, append-and-rescan denied.log.
+type queryLogEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	QName     string    `json:"qname"`
+	QType     string    `json:"qtype"`
+	Decision  string    `json:"decision"`
+	Upstream  string    `json:"upstream,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	Rcode     string    `json:"rcode"`
+}
+
+// queryLogger writes structured JSON query logs, one object per line,
+// rotating the underlying file aside by size so it never grows unbounded.
+type queryLogger struct {
+	mu       sync.Mutex
+	file     *os.File
+	path     string
+	maxBytes int64
+	written  int64
+}
+
+func newQueryLogger(path string) (*queryLogger, error) {
+	l := &queryLogger{path: path, maxBytes: defaultMaxQueryLogBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *queryLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.written = info.Size()
+	return nil
+}
+
+// log appends entry as a single JSON line, rotating the file first if it
+// would grow past maxBytes.
+func (l *queryLogger) log(entry queryLogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to marshal query log entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.written+int64(len(data)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("Failed to rotate query log: %v", err)
+		}
+	}
+
+	n, err := l.file.Write(data)
+	if err != nil {
+		log.Printf("Failed to write query log entry: %v", err)
+		return
+	}
+	l.written += int64(n)
+}
+
+// rotate closes the current log file, renames it aside with a timestamp
+// suffix, and opens a fresh file in its place.
+func (l *queryLogger) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+	}
+	rotated := fmt.Sprintf("%s.%s", l.path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(l.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// logQuery records one processed question. It's a no-op if the logger
+// hasn't been initialized, so tests and early startup don't need a stub.
+func logQuery(clientIP string, question dns.Question, decision, upstream string, latency time.Duration, rcode int) {
+	if queryLog == nil {
+		return
+	}
+	queryLog.log(queryLogEntry{
+		Time:      time.Now(),
+		Client:    clientIP,
+		QName:     question.Name,
+		QType:     dns.TypeToString[question.Qtype],
+		Decision:  decision,
+		Upstream:  upstream,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Rcode:     dns.RcodeToString[rcode],
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// bucketIdleTTL is how long a client's bucket may sit unused before it's
+// swept, so an attacker rotating or spoofing source IPs can't grow buckets
+// without bound and turn the rate limiter itself into a memory-exhaustion
+// vector.
+const bucketIdleTTL = 10 * time.Minute
+
+// sweepInterval bounds how often allow triggers a sweep of idle buckets, so
+// the sweep cost is amortized instead of paid on every query.
+const sweepInterval = time.Minute
+
+// tokenBucket tracks one client's remaining tokens, refilled continuously
+// at rate tokens/sec up to burst capacity.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// clientRateLimiter enforces a per-client-IP token bucket so a single
+// source can't use this resolver as free amplification beyond the LAN.
+type clientRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	rate      float64
+	burst     float64
+	lastSweep time.Time
+}
+
+func newClientRateLimiter(rate, burst float64) *clientRateLimiter {
+	return &clientRateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		rate:      rate,
+		burst:     burst,
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a query from clientIP may proceed, consuming one
+// token if so. The first query from a previously unseen client always
+// succeeds, starting it at a full bucket.
+func (l *clientRateLimiter) allow(clientIP string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[clientIP]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[clientIP] = b
+	} else {
+		b.tokens += now.Sub(b.lastSeen).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.lastSeen = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweepLocked evicts buckets idle longer than bucketIdleTTL, at most once
+// per sweepInterval. l.mu must be held.
+func (l *clientRateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	for ip, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTTL {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientIPFromAddr extracts the bare IP from a net.Addr, stripping the port,
+// falling back to the raw address string if it isn't host:port.
+func clientIPFromAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// zone holds the statically configured records, keyed by lowercased FQDN and
+// record type so a name can hold several types (A, MX, TXT, ...) and several
+// records of the same type. It is safe for concurrent use: queries read it
+// on every lookup while the admin API (see admin.go) can add or remove
+// records at runtime.
+type zone struct {
+	mu      sync.RWMutex
+	records map[string]map[uint16][]dns.RR
+	soa     *dns.SOA
+}
+
+func newZone() *zone {
+	return &zone{records: make(map[string]map[uint16][]dns.RR)}
+}
+
+// add inserts rr into the zone, indexed by its owner name and type.
+func (z *zone) add(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	qtype := rr.Header().Rrtype
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.records[name] == nil {
+		z.records[name] = make(map[uint16][]dns.RR)
+	}
+	z.records[name][qtype] = append(z.records[name][qtype], rr)
+	if soa, ok := rr.(*dns.SOA); ok {
+		z.soa = soa
+	}
+}
+
+// remove deletes every record of type qtype owned by name.
+func (z *zone) remove(name string, qtype uint16) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	types, ok := z.records[name]
+	if !ok {
+		return
+	}
+	delete(types, qtype)
+	if len(types) == 0 {
+		delete(z.records, name)
+	}
+}
+
+// lookup returns the records of type qtype for name, and whether name exists
+// in the zone at all under any type. Callers use nameExists to distinguish
+// NXDOMAIN (name doesn't exist) from NODATA (name exists, wrong type).
+func (z *zone) lookup(name string, qtype uint16) (rrs []dns.RR, nameExists bool) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	types, ok := z.records[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return types[qtype], true
+}
+
+// all returns every record currently stored in the zone.
+func (z *zone) all() []dns.RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	var out []dns.RR
+	for _, types := range z.records {
+		for _, rrs := range types {
+			out = append(out, rrs...)
+		}
+	}
+	return out
+}
+
+// save persists the zone back to filename as a BIND-style zonefile.
+func (z *zone) save(filename string) error {
+	rrs := z.all()
+	lines := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		lines = append(lines, rr.String())
+	}
+	return atomicWriteLines(filename, lines)
+}
+
+// loadRecords parses a BIND-style zonefile, one record per line, delegating
+// each line to dns.NewRR, e.g.:
+//
+//	example.com.     3600 IN A     93.184.216.34
+//	example.com.     3600 IN MX    10 mail.example.com.
+//	example.com.     3600 IN TXT   "v=spf1 -all"
+//	example.com.     3600 IN SOA   ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 3600
+func loadRecords(filename string) (*zone, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	z := newZone()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record: %s: %v", line, err)
+		}
+		if rr == nil {
+			continue
+		}
+		z.add(rr)
+	}
+
+	return z, scanner.Err()
+}
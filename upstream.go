@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSResponse mirrors the JSON shape returned by Google/Cloudflare style
+// DNS-over-HTTPS JSON APIs.
+type DNSResponse struct {
+	Status int         `json:"Status"`
+	Answer []DNSAnswer `json:"Answer"`
+}
+
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	TTL  int    `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// Upstream resolves a question of the given type against one configured
+// resolver. Implementations are selected per suffix by upstreamRouter.
+// edns carries the originating client's EDNS0 buffer size and DO bit, if it
+// sent one, so upstream queries advertise the same capabilities; it is nil
+// for clients that didn't use EDNS0.
+//
+// rcode is the upstream's reply code whenever err is nil, so callers can
+// tell a genuine NXDOMAIN (dns.RcodeNameError) apart from NODATA
+// (dns.RcodeSuccess with no answers). err is reserved for transport failures
+// and rcodes that mean the query itself couldn't be resolved (e.g.
+// SERVFAIL), neither of which should be treated as an answer worth caching.
+type Upstream interface {
+	Resolve(domain string, qtype uint16, edns *ednsOptions) (rrs []dns.RR, rcode int, err error)
+}
+
+// ednsOptions carries the EDNS0 buffer size and DNSSEC OK (DO) bit through
+// from the client's query to the upstream request.
+type ednsOptions struct {
+	bufSize uint16
+	do      bool
+}
+
+// applyEdns0 attaches edns to m as an OPT pseudo-record, if set.
+func applyEdns0(m *dns.Msg, edns *ednsOptions) {
+	if edns == nil {
+		return
+	}
+	m.SetEdns0(edns.bufSize, edns.do)
+}
+
+// classicResolver forwards queries via plain UDP or TCP using miekg/dns.
+type classicResolver struct {
+	addr string
+	net  string // "udp" or "tcp"
+}
+
+func newClassicResolver(addr, net string) *classicResolver {
+	return &classicResolver{addr: addr, net: net}
+}
+
+func (r *classicResolver) Resolve(domain string, qtype uint16, edns *ednsOptions) ([]dns.RR, int, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), qtype)
+	applyEdns0(m, edns)
+
+	c := &dns.Client{Net: r.net}
+	resp, _, err := c.Exchange(m, r.addr)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("classic resolver %s: %v", r.addr, err)
+	}
+	if err := checkRcode(resp, r.addr); err != nil {
+		return nil, resp.Rcode, err
+	}
+	return resp.Answer, resp.Rcode, nil
+}
+
+// dotResolver forwards queries via DNS-over-TLS.
+type dotResolver struct {
+	addr string // host:port of the TLS-enabled resolver
+}
+
+func newDotResolver(addr string) *dotResolver {
+	return &dotResolver{addr: addr}
+}
+
+func (r *dotResolver) Resolve(domain string, qtype uint16, edns *ednsOptions) ([]dns.RR, int, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), qtype)
+	applyEdns0(m, edns)
+
+	c := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{}}
+	resp, _, err := c.Exchange(m, r.addr)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("DoT resolver %s: %v", r.addr, err)
+	}
+	if err := checkRcode(resp, r.addr); err != nil {
+		return nil, resp.Rcode, err
+	}
+	return resp.Answer, resp.Rcode, nil
+}
+
+// checkRcode reports an error for any rcode other than a genuine answer to
+// the question asked. NXDOMAIN is a valid, cacheable answer (the name just
+// doesn't exist), not a failure, so it passes through alongside
+// RcodeSuccess; everything else (SERVFAIL, REFUSED, ...) means the upstream
+// couldn't actually resolve the query.
+func checkRcode(resp *dns.Msg, source string) error {
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return fmt.Errorf("%s returned rcode %d", source, resp.Rcode)
+	}
+	return nil
+}
+
+// dohResolver forwards queries to a DNS-over-HTTPS endpoint, either via the
+// legacy Google/Cloudflare JSON API or RFC 8484 DNS wire format.
+type dohResolver struct {
+	endpoint   string
+	wireFormat bool
+}
+
+func newDoHResolver(endpoint string, wireFormat bool) *dohResolver {
+	return &dohResolver{endpoint: endpoint, wireFormat: wireFormat}
+}
+
+func (r *dohResolver) Resolve(domain string, qtype uint16, edns *ednsOptions) ([]dns.RR, int, error) {
+	if r.wireFormat {
+		return r.resolveWire(domain, qtype, edns)
+	}
+	// The JSON API has no way to carry a client's EDNS0 buffer size or DO
+	// bit through to the upstream, so edns is only honored in wire mode.
+	return r.resolveJSON(domain, qtype)
+}
+
+func (r *dohResolver) resolveJSON(domain string, qtype uint16) ([]dns.RR, int, error) {
+	typeName, ok := dns.TypeToString[qtype]
+	if !ok {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("unsupported query type %d for DoH JSON API", qtype)
+	}
+
+	query := url.Values{}
+	query.Set("name", domain)
+	query.Set("type", typeName)
+
+	fullURL := fmt.Sprintf("%s?%s", r.endpoint, query.Encode())
+	resp, err := http.Get(fullURL)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to query DoH endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	var dnsResp DNSResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dnsResp); err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to parse DNS response: %v", err)
+	}
+
+	// dnsResp.Status is the response's RCODE per the JSON API spec, so
+	// NXDOMAIN (status 3) is a real answer, not a failure; see checkRcode.
+	if dnsResp.Status != dns.RcodeSuccess && dnsResp.Status != dns.RcodeNameError {
+		return nil, dnsResp.Status, fmt.Errorf("DNS query failed with status: %d", dnsResp.Status)
+	}
+
+	rrs := make([]dns.RR, 0, len(dnsResp.Answer))
+	for _, ans := range dnsResp.Answer {
+		ansTypeName, ok := dns.TypeToString[uint16(ans.Type)]
+		if !ok {
+			continue
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(ans.Name), ans.TTL, ansTypeName, ans.Data))
+		if err != nil {
+			return nil, dns.RcodeServerFailure, fmt.Errorf("failed to parse DoH answer %q: %v", ans.Data, err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, dnsResp.Status, nil
+}
+
+func (r *dohResolver) resolveWire(domain string, qtype uint16, edns *ednsOptions) ([]dns.RR, int, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), qtype)
+	applyEdns0(m, edns)
+
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to pack DNS query: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, strings.NewReader(string(packed)))
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to build DoH wire request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to query DoH endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to read DoH wire response: %v", err)
+	}
+
+	wireResp := new(dns.Msg)
+	if err := wireResp.Unpack(body); err != nil {
+		return nil, dns.RcodeServerFailure, fmt.Errorf("failed to unpack DoH wire response: %v", err)
+	}
+	if err := checkRcode(wireResp, r.endpoint); err != nil {
+		return nil, wireResp.Rcode, err
+	}
+
+	return wireResp.Answer, wireResp.Rcode, nil
+}
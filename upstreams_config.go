@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// upstreamRoute binds a domain suffix to the Upstream that should handle it.
+type upstreamRoute struct {
+	suffix   string
+	upstream Upstream
+}
+
+// upstreamRouter selects the most specific configured Upstream for a domain,
+// falling back to the "." default route when nothing more specific matches.
+type upstreamRouter struct {
+	routes []upstreamRoute
+}
+
+func newUpstreamRouter() *upstreamRouter {
+	return &upstreamRouter{}
+}
+
+// add registers an Upstream for suffix, keeping routes sorted longest-first
+// so resolve always finds the most specific match.
+func (u *upstreamRouter) add(suffix string, upstream Upstream) {
+	u.routes = append(u.routes, upstreamRoute{suffix: strings.ToLower(suffix), upstream: upstream})
+	sort.SliceStable(u.routes, func(i, j int) bool {
+		return len(u.routes[i].suffix) > len(u.routes[j].suffix)
+	})
+}
+
+// resolve returns the Upstream configured for domain, or nil if no route
+// (including the default ".") matches.
+func (u *upstreamRouter) resolve(domain string) Upstream {
+	upstream, _ := u.resolveRoute(domain)
+	return upstream
+}
+
+// resolveRoute behaves like resolve, but also returns the suffix of the
+// matched route (e.g. for query logging), or "" alongside a nil Upstream
+// when nothing matches.
+func (u *upstreamRouter) resolveRoute(domain string) (Upstream, string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, route := range u.routes {
+		if route.suffix == "." {
+			return route.upstream, route.suffix
+		}
+		if domain == route.suffix || strings.HasSuffix(domain, "."+route.suffix) {
+			return route.upstream, route.suffix
+		}
+	}
+	return nil, ""
+}
+
+// loadUpstreams parses a simple "suffix: server" routing table, one entry
+// per line, e.g.:
+//
+//	myhome.lan: udp://10.0.1.1:53
+//	corp: https://internal/dns-query
+//	.: https://dns.google.com/resolve
+//
+// The scheme on the server side selects the resolver implementation:
+// udp:// and tcp:// for classic resolvers, tls:// for DoT, https:// for the
+// DoH JSON API, and https+wire:// for DoH in RFC 8484 wire format.
+func loadUpstreams(filename string) (*upstreamRouter, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	router := newUpstreamRouter()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sep := strings.Index(line, ": ")
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid upstream route: %s", line)
+		}
+		suffix := strings.TrimSpace(line[:sep])
+		server := strings.TrimSpace(line[sep+2:])
+
+		upstream, err := parseUpstream(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream route %q: %v", line, err)
+		}
+		router.add(suffix, upstream)
+	}
+
+	return router, scanner.Err()
+}
+
+// loadUpstreamsOrDefault behaves like loadUpstreams, but falls back to a
+// single default route through the legacy Google DoH JSON API when filename
+// does not exist, preserving the old hardcoded behavior out of the box.
+func loadUpstreamsOrDefault(filename string) (*upstreamRouter, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		router := newUpstreamRouter()
+		router.add(".", newDoHResolver("https://dns.google.com/resolve", false))
+		return router, nil
+	}
+	return loadUpstreams(filename)
+}
+
+func parseUpstream(server string) (Upstream, error) {
+	switch {
+	case strings.HasPrefix(server, "udp://"):
+		return newClassicResolver(strings.TrimPrefix(server, "udp://"), "udp"), nil
+	case strings.HasPrefix(server, "tcp://"):
+		return newClassicResolver(strings.TrimPrefix(server, "tcp://"), "tcp"), nil
+	case strings.HasPrefix(server, "tls://"):
+		return newDotResolver(strings.TrimPrefix(server, "tls://")), nil
+	case strings.HasPrefix(server, "https+wire://"):
+		return newDoHResolver("https://"+strings.TrimPrefix(server, "https+wire://"), true), nil
+	case strings.HasPrefix(server, "https://"):
+		return newDoHResolver(server, false), nil
+	default:
+		return nil, fmt.Errorf("unrecognized upstream scheme: %s", server)
+	}
+}